@@ -0,0 +1,148 @@
+package memberlist
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ugorji/go/codec"
+)
+
+// supportedCompressionAlgos is advertised in the hello handshake so a
+// peer knows which compressionType values we can actually decode;
+// exchangeHello keeps Config.CompressionAlgo if the remote side listed
+// it, and otherwise picks the first of these the remote side also listed.
+func supportedCompressionAlgos() []compressionType {
+	return []compressionType{deflateAlgo, lz4Algo, zstdAlgo}
+}
+
+// maxDecompressedSize bounds how much output decompressBuffer will
+// accept from a single compressMsg. Without a cap, a small frame with a
+// high compression ratio (zstd especially) could expand into gigabytes
+// and exhaust memory before any higher-level size check ever runs.
+const maxDecompressedSize = 10 * helloMaxMsgSize
+
+// compressPayload compresses inp with algo and wraps the result in a
+// compressMsg frame (type/version header plus a msgpack-encoded compress
+// struct), ready to use as a packet payload or prepended to a stream.
+func compressPayload(inp []byte, algo compressionType) (*bytes.Buffer, error) {
+	compressed, err := compressBytes(inp, algo)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compress{
+		Algo: algo,
+		Buf:  compressed,
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(compressMsg))
+	buf.WriteByte(byte(messageTypeVersions[compressMsg]))
+
+	handle := codec.MsgpackHandle{}
+	enc := codec.NewEncoder(&buf, &handle)
+	if err := enc.Encode(&c); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// compressBytes compresses inp with algo and returns the raw compressed
+// bytes, with no framing of their own.
+func compressBytes(inp []byte, algo compressionType) ([]byte, error) {
+	var buf bytes.Buffer
+	switch algo {
+	case deflateAlgo:
+		w, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(inp); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case lz4Algo:
+		w := lz4.NewWriter(&buf)
+		if _, err := w.Write(inp); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case zstdAlgo:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(inp); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot compress, unknown algorithm %d", algo)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload decodes buf as a compressMsg body (the msgpack
+// compress struct, with the leading type/version bytes already stripped
+// by the caller) and returns the decompressed payload.
+func decompressPayload(buf []byte) ([]byte, error) {
+	var c compress
+	handle := codec.MsgpackHandle{}
+	dec := codec.NewDecoder(bytes.NewReader(buf), &handle)
+	if err := dec.Decode(&c); err != nil {
+		return nil, err
+	}
+	return decompressBuffer(&c)
+}
+
+// decompressBuffer decompresses c.Buf according to c.Algo, returning a
+// clean error rather than panicking when c.Algo is an id we don't
+// recognize, which can happen if a newer peer picks a codec we don't
+// have, or it sent garbage.
+func decompressBuffer(c *compress) ([]byte, error) {
+	r, err := decompressReader(c)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed payload exceeds the %d byte limit", maxDecompressedSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressReader returns an io.ReadCloser that streams the
+// decompressed form of c.Buf according to c.Algo.
+func decompressReader(c *compress) (io.ReadCloser, error) {
+	src := bytes.NewReader(c.Buf)
+	switch c.Algo {
+	case deflateAlgo:
+		return flate.NewReader(src), nil
+	case lz4Algo:
+		return io.NopCloser(lz4.NewReader(src)), nil
+	case zstdAlgo:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("cannot decompress, unknown algorithm %d", c.Algo)
+	}
+}
@@ -0,0 +1,81 @@
+package memberlist
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestCompressBytes_Roundtrip(t *testing.T) {
+	msg := []byte("the quick brown fox jumps over the lazy dog")
+
+	for _, algo := range supportedCompressionAlgos() {
+		algo := algo
+		t.Run(fmt.Sprintf("algo=%d", algo), func(t *testing.T) {
+			compressed, err := compressBytes(msg, algo)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+
+			c := compress{Algo: algo, Buf: compressed}
+			decompressed, err := decompressBuffer(&c)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !bytes.Equal(decompressed, msg) {
+				t.Fatalf("bad: %v", decompressed)
+			}
+		})
+	}
+}
+
+func TestCompressPayload_DecompressPayload_Roundtrip(t *testing.T) {
+	msg := []byte("the quick brown fox jumps over the lazy dog")
+
+	buf, err := compressPayload(msg, lz4Algo)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Strip the type/version header compressPayload prepends, mirroring
+	// what handleCommand does before handleCompressed calls
+	// decompressPayload.
+	body := buf.Bytes()[2:]
+	decompressed, err := decompressPayload(body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(decompressed, msg) {
+		t.Fatalf("bad: %v", decompressed)
+	}
+}
+
+func TestCompressBytes_UnknownAlgo(t *testing.T) {
+	if _, err := compressBytes([]byte("test"), compressionType(255)); err == nil {
+		t.Fatalf("expected error for unknown compression algorithm")
+	}
+}
+
+func TestDecompressBuffer_UnknownAlgo(t *testing.T) {
+	c := compress{Algo: compressionType(255), Buf: []byte("test")}
+	if _, err := decompressBuffer(&c); err == nil {
+		t.Fatalf("expected error for unknown compression algorithm")
+	}
+}
+
+func TestDecompressBuffer_ExceedsMaxSize(t *testing.T) {
+	// A large, highly repetitive input compresses down to almost nothing
+	// but expands back past maxDecompressedSize, the exact zip-bomb shape
+	// the size cap in decompressBuffer exists to reject.
+	huge := make([]byte, maxDecompressedSize+1024)
+
+	compressed, err := compressBytes(huge, deflateAlgo)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	c := compress{Algo: deflateAlgo, Buf: compressed}
+	if _, err := decompressBuffer(&c); err == nil {
+		t.Fatalf("expected decompressBuffer to reject output over maxDecompressedSize")
+	}
+}
@@ -0,0 +1,133 @@
+package memberlist
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// Keyring manages the set of encryption keys used by memberlist to
+// guard its messages with. The "primary" key is used for all outgoing
+// messages, and any key in the ring can be used to decrypt incoming
+// messages. This allows for key rotation without disrupting a running
+// cluster: push a new key to every node, promote it to primary with
+// UseKey, then remove the old one once nobody needs it anymore.
+type Keyring struct {
+	l sync.Mutex
+
+	// keys holds the primary key first, followed by the rest. UseKey
+	// swaps the old primary into the promoted key's slot, so after a
+	// rotation the rest are not necessarily in the order they were added.
+	keys [][]byte
+}
+
+// NewKeyring constructs a new container for a set of encryption keys.
+// While the keyring may be initialized without any keys, a primaryKey
+// must be provided either explicitly or as the first entry of keys, as
+// it is required to encrypt outgoing messages.
+func NewKeyring(keys [][]byte, primaryKey []byte) (*Keyring, error) {
+	keyring := &Keyring{}
+	if len(primaryKey) == 0 {
+		if len(keys) > 0 {
+			primaryKey = keys[0]
+		} else {
+			return nil, fmt.Errorf("Empty primary key not allowed")
+		}
+	}
+
+	if err := keyring.AddKey(primaryKey); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := keyring.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// AddKey always leaves the first-added key as primary, so make sure
+	// the caller's chosen primaryKey is the one in front.
+	if err := keyring.UseKey(primaryKey); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// AddKey will install a new key on the ring. Adding a key that already
+// exists is a no-op.
+func (k *Keyring) AddKey(key []byte) error {
+	if l := len(key); l != 16 && l != 24 && l != 32 {
+		return fmt.Errorf("key size must be 16, 24 or 32 bytes")
+	}
+
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	for _, installed := range k.keys {
+		if bytes.Equal(installed, key) {
+			return nil
+		}
+	}
+
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// UseKey changes the key used to encrypt messages to the one given. This
+// key must already be installed on the ring, since peers must learn it
+// out of band before we start encrypting with it.
+func (k *Keyring) UseKey(key []byte) error {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	for i, installed := range k.keys {
+		if bytes.Equal(key, installed) {
+			k.keys[0], k.keys[i] = k.keys[i], k.keys[0]
+			return nil
+		}
+	}
+	return fmt.Errorf("Requested key is not in the keyring")
+}
+
+// RemoveKey drops a key from the keyring. The primary key (position 0)
+// may not be removed; call UseKey to promote a different key first.
+func (k *Keyring) RemoveKey(key []byte) error {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	if len(k.keys) > 0 && bytes.Equal(k.keys[0], key) {
+		return fmt.Errorf("Removing the primary key is not allowed")
+	}
+	for i, installed := range k.keys {
+		if bytes.Equal(key, installed) {
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// GetKeys returns a copy of the current set of keys on the ring, primary
+// first. This is the order decryptPayload tries them in. A copy is
+// returned rather than the live slice because decryptPayload ranges over
+// the result after the lock is released, and a concurrent UseKey or
+// RemoveKey during a live rotation mutates k.keys in place.
+func (k *Keyring) GetKeys() [][]byte {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	keys := make([][]byte, len(k.keys))
+	copy(keys, k.keys)
+	return keys
+}
+
+// GetPrimaryKey returns the key at position 0, which is the one used to
+// encrypt outgoing messages.
+func (k *Keyring) GetPrimaryKey() (key []byte) {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	if len(k.keys) > 0 {
+		key = k.keys[0]
+	}
+	return
+}
@@ -3,6 +3,7 @@ package memberlist
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/ugorji/go/codec"
 	"io"
@@ -35,6 +36,8 @@ const (
 	compoundMsg
 	userMsg // User mesg, not handled by us
 	compressMsg
+	encryptMsg
+	helloMsg
 )
 
 // compressionType is used to specify the compression algorithm
@@ -42,6 +45,8 @@ type compressionType uint8
 
 const (
 	deflateAlgo compressionType = iota
+	lz4Algo
+	zstdAlgo
 )
 
 // The list of the current versions of the message types.
@@ -59,6 +64,8 @@ func init() {
 		compoundMsg:     0,
 		userMsg:         0,
 		compressMsg:     0,
+		encryptMsg:      0,
+		helloMsg:        0,
 	}
 }
 
@@ -71,6 +78,18 @@ const (
 	udpSendBuf             = 1400
 	userMsgOverhead        = 1
 	blockingWarning        = 10 * time.Millisecond // Warn if a UDP packet takes this long to process
+
+	// helloMaxMsgSize is the largest push/pull message this side is
+	// willing to read, advertised in the hello handshake so the other
+	// side can pin to whichever of the two is smaller.
+	helloMaxMsgSize = 10 * 1024 * 1024
+
+	// minPushNodeStateSize is a conservative lower bound on how many bytes
+	// decoding a single pushNodeState into memory costs (its string and
+	// slice headers alone run well past this), used to turn a negotiated
+	// byte-size limit into a sane cap on header.Nodes before it's trusted
+	// for an upfront slice allocation.
+	minPushNodeStateSize = 64
 )
 
 // ping request sent directly to node
@@ -118,6 +137,31 @@ type pushPullHeader struct {
 	UserStateLen int // Encodes the byte lengh of user state
 }
 
+// hello is the handshake frame exchanged as the very first thing on
+// every new push/pull TCP connection, analogous to a 9P Tversion/Rversion.
+// It carries the sender's supported version for each messageType plus
+// the largest push/pull message it's willing to read, so the rest of the
+// exchange on this connection can pin to whatever both sides actually
+// understand instead of relying on the blunt ±1 rule in validVersion.
+// This is also where future per-connection negotiation (compression
+// algorithm, encryption suite, max compound size) belongs, rather than
+// each becoming its own top-level messageType.
+//
+// The handshake is mandatory: a peer built before helloMsg existed reads
+// it as an unrecognized push/pull frame and the sync fails. Introducing
+// it is a one-time cutover, the same as any other wire-incompatible
+// change to this protocol, so it must roll out to every node before a
+// push/pull sync is attempted between an old and a new one.
+type hello struct {
+	Versions   map[messageType]messageVersion
+	MaxMsgSize int
+
+	// CompressionAlgos lists every compressionType this side can decode,
+	// so the other side can tell whether the compressMsg frames it sends
+	// over this connection will actually be understood.
+	CompressionAlgos []compressionType
+}
+
 // pushNodeState is used for pushPullReq when we are
 // transfering out node states
 type pushNodeState struct {
@@ -135,46 +179,39 @@ type compress struct {
 	Buf  []byte
 }
 
-// setUDPRecvBuf is used to resize the UDP receive window. The function
-// attempts to set the read buffer to `udpRecvBuf` but backs off until
-// the read buffer can be set.
-func setUDPRecvBuf(c *net.UDPConn) {
-	size := udpRecvBuf
+// streamListen consumes connections handed to us by the transport and
+// dispatches each to handleConn. It replaces the old direct AcceptTCP loop
+// now that the transport owns the actual listener.
+func (m *Memberlist) streamListen() {
 	for {
-		if err := c.SetReadBuffer(size); err == nil {
+		conn, ok := <-m.transport.StreamCh()
+		if !ok {
 			break
 		}
-		size = size / 2
-	}
-}
-
-// tcpListen listens for and handles incoming connections
-func (m *Memberlist) tcpListen() {
-	for {
-		conn, err := m.tcpListener.AcceptTCP()
-		if err != nil {
-			if m.shutdown {
-				break
-			}
-			m.logger.Printf("[ERR] Error accepting TCP connection: %s", err)
-			continue
-		}
 		go m.handleConn(conn)
 	}
 }
 
 // handleConn handles a single incoming TCP connection
-func (m *Memberlist) handleConn(conn *net.TCPConn) {
+func (m *Memberlist) handleConn(conn net.Conn) {
 	m.logger.Printf("[INFO] Responding to push/pull sync with: %s", conn.RemoteAddr())
 	defer conn.Close()
 
-	remoteNodes, userState, err := readRemoteState(conn)
+	bufConn := bufio.NewReader(conn)
+
+	hs, err := m.exchangeHello(conn, bufConn, false)
+	if err != nil {
+		m.logger.Printf("[ERR] Failed to exchange version hello: %s", err)
+		return
+	}
+
+	remoteNodes, userState, err := m.readRemoteState(bufConn, hs)
 	if err != nil {
 		m.logger.Printf("[ERR] Failed to receive remote state: %s", err)
 		return
 	}
 
-	if err := m.sendLocalState(conn); err != nil {
+	if err := m.sendLocalState(conn, hs); err != nil {
 		m.logger.Printf("[ERR] Failed to push local state: %s", err)
 	}
 
@@ -187,56 +224,62 @@ func (m *Memberlist) handleConn(conn *net.TCPConn) {
 	}
 }
 
-// udpListen listens for and handles incoming UDP packets
-func (m *Memberlist) udpListen() {
-	mainBuf := make([]byte, udpBufSize)
-	var n int
-	var addr net.Addr
-	var err error
+// packetListen consumes packets handed to us by the transport and
+// dispatches each to handleCommand. It replaces the old direct ReadFrom
+// loop now that the transport owns the actual socket.
+func (m *Memberlist) packetListen() {
 	var lastPacket time.Time
 	for {
+		packet, ok := <-m.transport.PacketCh()
+		if !ok {
+			break
+		}
+
 		// Do a check for potentially blocking operations
-		if !lastPacket.IsZero() && time.Now().Sub(lastPacket) > blockingWarning {
-			diff := time.Now().Sub(lastPacket)
+		if !lastPacket.IsZero() && packet.Timestamp.Sub(lastPacket) > blockingWarning {
+			diff := packet.Timestamp.Sub(lastPacket)
 			m.logger.Printf(
 				"[WARN] Potential blocking operation. Last command took %v",
 				diff)
 		}
-
-		// Reset buffer
-		buf := mainBuf[0:udpBufSize]
-
-		// Read a packet
-		n, addr, err = m.udpListener.ReadFrom(buf)
-		if err != nil {
-			if m.shutdown {
-				break
-			}
-			m.logger.Printf("[ERR] Error reading UDP packet: %s", err)
-			continue
-		}
-
-		// Check the length
-		if n < 1 {
-			m.logger.Printf("[ERR] UDP packet too short (%d bytes). From: %s",
-				len(buf), addr)
-			continue
-		}
-
-		// Capture the current time
-		lastPacket = time.Now()
+		lastPacket = packet.Timestamp
 
 		// Handle the command
-		m.handleCommand(buf[:n], addr)
+		m.handleCommand(packet.Buf, packet.From)
 	}
 }
 
 func (m *Memberlist) handleCommand(buf []byte, from net.Addr) {
+	// handleCompressed/handleEncrypted recurse back in here with whatever
+	// decompressPayload/decryptPayload handed back, and neither of those
+	// guarantees at least 2 bytes: a compressMsg can decompress to
+	// nothing, and any key in the keyring can produce a valid decrypt of
+	// a 0- or 1-byte plaintext. The UDP read loop only bounds the
+	// original wire packet, not payloads unwrapped from inside it.
+	if len(buf) < minPacketSize {
+		m.logger.Printf("[ERR] UDP message too short (%d bytes) from %s", len(buf), from)
+		return
+	}
+
 	// Decode the message type
 	msgType := messageType(buf[0])
 	msgVersion := messageVersion(buf[1])
 	buf = buf[2:]
 
+	// Enforce the encryption policy before going any further: an
+	// encrypted frame with no keyring configured can't be trusted, and a
+	// cleartext frame when encryption is required is a policy violation,
+	// not just a decode error.
+	if msgType == encryptMsg {
+		if !m.config.EncryptionEnabled() {
+			m.logger.Printf("[ERR] Encryption is not configured but received an encrypted message")
+			return
+		}
+	} else if m.config.EncryptionEnabled() && m.config.GossipVerifyIncomingData {
+		m.logger.Printf("[ERR] Encryption is required but received a cleartext message")
+		return
+	}
+
 	// Verify that we can process this version
 	if !validVersion(msgType, msgVersion) {
 		m.logger.Printf("[ERR] Received message with a bad version: %d", msgVersion)
@@ -247,6 +290,8 @@ func (m *Memberlist) handleCommand(buf []byte, from net.Addr) {
 	switch msgType {
 	case compoundMsg:
 		m.handleCompound(buf, from)
+	case encryptMsg:
+		m.handleEncrypted(buf, from)
 	case pingMsg:
 		m.handlePing(buf, from)
 	case indirectPingMsg:
@@ -383,6 +428,20 @@ func (m *Memberlist) handleCompressed(buf []byte, from net.Addr) {
 	m.handleCommand(payload, from)
 }
 
+// handleEncrypted is used to unpack an encrypted message. The keyring is
+// tried key by key so a key that is mid-rotation still decrypts.
+func (m *Memberlist) handleEncrypted(buf []byte, from net.Addr) {
+	plain, err := decryptPayload(m.config.Keyring.GetKeys(), buf)
+	if err != nil {
+		m.logger.Printf("[ERR] Failed to decrypt UDP message: %v", err)
+		return
+	}
+
+	// Recursively handle the plaintext, which is itself a full message
+	// (type, version and body) and may in turn be a compressMsg.
+	m.handleCommand(plain, from)
+}
+
 // encodeAndSendMsg is used to combine the encoding and sending steps
 func (m *Memberlist) encodeAndSendMsg(to net.Addr, msgType messageType, msg interface{}) error {
 	out, err := encode(msgType, msg)
@@ -419,11 +478,17 @@ func (m *Memberlist) sendMsg(to net.Addr, msg []byte) error {
 	return m.rawSendMsg(to, compound.Bytes())
 }
 
-// rawSendMsg is used to send a UDP message to another host without modification
+// rawSendMsg is used to send a UDP message to another host without
+// modification. Unlike the TCP push/pull path, there's no per-connection
+// hello handshake to confirm the peer can decode m.config.CompressionAlgo;
+// UDP gossip compression is a cluster-wide setting operators must roll
+// out compatibly, the same as EnableCompression itself already requires.
 func (m *Memberlist) rawSendMsg(to net.Addr, msg []byte) error {
-	// Check if we have compression enabled
-	if m.config.EnableCompression {
-		buf, err := compressPayload(msg)
+	// Check if we have compression enabled. Tiny packets (pings, acks)
+	// skip it entirely since the codec and frame overhead typically
+	// outweighs whatever compression would save.
+	if m.config.EnableCompression && len(msg) >= m.config.MinCompressSize {
+		buf, err := compressPayload(msg, m.config.CompressionAlgo)
 		if err != nil {
 			m.logger.Printf("[WARN] Failed to compress payload: %v", err)
 		} else {
@@ -431,29 +496,136 @@ func (m *Memberlist) rawSendMsg(to net.Addr, msg []byte) error {
 		}
 	}
 
-	_, err := m.udpListener.WriteTo(msg, to)
+	// Check if we have encryption enabled
+	if m.config.EncryptionEnabled() {
+		crypt, err := m.encryptPacket(msg)
+		if err != nil {
+			m.logger.Printf("[ERR] Failed to encrypt packet: %v", err)
+			return err
+		}
+		msg = crypt
+	}
+
+	_, err := m.transport.WriteTo(msg, to.String())
 	return err
 }
 
+// sendMsgs is sendMsg generalized to a set of destinations that should
+// all receive the same payload, such as a broadcast round's fan-out or
+// the peers chosen for an indirect ping. It piggybacks pending broadcasts
+// exactly like sendMsg, then hands the result to rawSendMsgs so it can go
+// out in a single sendmmsg(2) call when the transport supports batching.
+func (m *Memberlist) sendMsgs(tos []net.Addr, msg []byte) error {
+	// Check if we can piggy back any messages
+	bytesAvail := udpSendBuf - len(msg) - compoundHeaderOverhead
+	extra := m.getBroadcasts(compoundOverhead, bytesAvail)
+
+	// Fast path if nothing to piggypack
+	if len(extra) == 0 {
+		return m.rawSendMsgs(tos, msg)
+	}
+
+	// Join all the messages
+	msgs := make([][]byte, 0, 1+len(extra))
+	msgs = append(msgs, msg)
+	msgs = append(msgs, extra...)
+
+	// Create a compound message
+	compound := makeCompoundMessage(msgs)
+
+	// Send the message
+	return m.rawSendMsgs(tos, compound.Bytes())
+}
+
+// rawSendMsgs is rawSendMsg generalized to many destinations receiving
+// the same bytes. Compression and encryption, when enabled, are applied
+// once and reused for every destination instead of once per peer.
+func (m *Memberlist) rawSendMsgs(tos []net.Addr, msg []byte) error {
+	// Check if we have compression enabled. Tiny packets (pings, acks)
+	// skip it entirely since the codec and frame overhead typically
+	// outweighs whatever compression would save.
+	if m.config.EnableCompression && len(msg) >= m.config.MinCompressSize {
+		buf, err := compressPayload(msg, m.config.CompressionAlgo)
+		if err != nil {
+			m.logger.Printf("[WARN] Failed to compress payload: %v", err)
+		} else {
+			msg = buf.Bytes()
+		}
+	}
+
+	// Check if we have encryption enabled
+	if m.config.EncryptionEnabled() {
+		crypt, err := m.encryptPacket(msg)
+		if err != nil {
+			m.logger.Printf("[ERR] Failed to encrypt packet: %v", err)
+			return err
+		}
+		msg = crypt
+	}
+
+	// Fan the batch out in one syscall when the transport supports it;
+	// a single destination gets nothing from sendmmsg, so it always goes
+	// through the plain per-destination path below.
+	if bt, ok := m.transport.(BatchTransport); ok && m.config.EnableUDPOffload && len(tos) > 1 {
+		addrs := make([]string, len(tos))
+		msgs := make([][]byte, len(tos))
+		for i, to := range tos {
+			addrs[i] = to.String()
+			msgs[i] = msg
+		}
+		_, err := bt.WriteToBatch(msgs, addrs)
+		return err
+	}
+
+	var firstErr error
+	for _, to := range tos {
+		if _, err := m.transport.WriteTo(msg, to.String()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// encryptPacket wraps msg with the encryptMsg type/version header and
+// encrypts it under the keyring's primary key, for transmission over UDP.
+func (m *Memberlist) encryptPacket(msg []byte) ([]byte, error) {
+	crypt, err := encryptPayload(m.config.Keyring.GetPrimaryKey(), msg)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(crypt))
+	out[0] = byte(encryptMsg)
+	out[1] = byte(messageTypeVersions[encryptMsg])
+	out = append(out, crypt...)
+	return out, nil
+}
+
 // sendState is used to initiate a push/pull over TCP with a remote node
 func (m *Memberlist) sendAndReceiveState(addr []byte) ([]pushNodeState, []byte, error) {
 	// Attempt to connect
-	dialer := net.Dialer{Timeout: m.config.TCPTimeout}
 	dest := net.TCPAddr{IP: addr, Port: m.config.TCPPort}
-	conn, err := dialer.Dial("tcp", dest.String())
+	conn, err := m.transport.DialTimeout(dest.String(), m.config.TCPTimeout)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer conn.Close()
 	m.logger.Printf("[INFO] Initiating push/pull sync with: %s", conn.RemoteAddr())
 
+	bufConn := bufio.NewReader(conn)
+
+	hs, err := m.exchangeHello(conn, bufConn, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Send our state
-	if err := m.sendLocalState(conn); err != nil {
+	if err := m.sendLocalState(conn, hs); err != nil {
 		return nil, nil, err
 	}
 
 	// Read remote state
-	remote, userState, err := readRemoteState(conn)
+	remote, userState, err := m.readRemoteState(bufConn, hs)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -462,8 +634,147 @@ func (m *Memberlist) sendAndReceiveState(addr []byte) ([]pushNodeState, []byte,
 	return remote, userState, nil
 }
 
-// sendLocalState is invoked to send our local state over a tcp connection
-func (m *Memberlist) sendLocalState(conn net.Conn) error {
+// negotiatedHello is the per-connection result of exchangeHello: the
+// version both sides will use for each messageType, the smaller of the
+// two advertised MaxMsgSize values, and whichever compressionType (if
+// any) both sides can decode.
+type negotiatedHello struct {
+	versions        map[messageType]messageVersion
+	maxMsgSize      int
+	compressionAlgo compressionType
+	compressionOK   bool
+}
+
+// exchangeHello performs the hello handshake that has to be the first
+// thing on a new push/pull connection: the initiator writes its hello
+// and then reads the responder's, while the responder does the reverse,
+// mirroring how the rest of the exchange is ordered (sendAndReceiveState
+// writes before it reads; handleConn reads before it writes). bufConn
+// must be the same buffered reader later passed to readRemoteState, so
+// nothing readHello pulls out of the socket's bufio buffer is lost. The
+// result is threaded through to sendLocalState and readRemoteState.
+func (m *Memberlist) exchangeHello(conn net.Conn, bufConn *bufio.Reader, initiator bool) (negotiatedHello, error) {
+	local := hello{
+		Versions:         messageTypeVersions,
+		MaxMsgSize:       helloMaxMsgSize,
+		CompressionAlgos: supportedCompressionAlgos(),
+	}
+
+	var remote hello
+	var err error
+	if initiator {
+		err = writeHello(conn, &local)
+		if err == nil {
+			remote, err = readHello(bufConn)
+		}
+	} else {
+		remote, err = readHello(bufConn)
+		if err == nil {
+			err = writeHello(conn, &local)
+		}
+	}
+	if err != nil {
+		return negotiatedHello{}, err
+	}
+	if remote.MaxMsgSize <= 0 {
+		return negotiatedHello{}, fmt.Errorf("remote hello advertised a non-positive MaxMsgSize: %d", remote.MaxMsgSize)
+	}
+
+	versions := make(map[messageType]messageVersion, len(local.Versions))
+	for msgType, ourVersion := range local.Versions {
+		theirVersion, ok := remote.Versions[msgType]
+		if !ok || theirVersion > ourVersion {
+			versions[msgType] = ourVersion
+			continue
+		}
+		versions[msgType] = theirVersion
+	}
+
+	maxMsgSize := local.MaxMsgSize
+	if remote.MaxMsgSize < maxMsgSize {
+		maxMsgSize = remote.MaxMsgSize
+	}
+
+	// Prefer our configured algorithm if the remote can decode it;
+	// otherwise fall back to the first of our own supported algorithms
+	// (in supportedCompressionAlgos order) that the remote also listed.
+	// If neither side has anything in common, compression is simply
+	// skipped for this connection.
+	remoteAlgos := make(map[compressionType]bool, len(remote.CompressionAlgos))
+	for _, algo := range remote.CompressionAlgos {
+		remoteAlgos[algo] = true
+	}
+	compressionAlgo := m.config.CompressionAlgo
+	compressionOK := remoteAlgos[compressionAlgo]
+	if !compressionOK {
+		for _, algo := range local.CompressionAlgos {
+			if remoteAlgos[algo] {
+				compressionAlgo = algo
+				compressionOK = true
+				break
+			}
+		}
+	}
+
+	return negotiatedHello{
+		versions:        versions,
+		maxMsgSize:      maxMsgSize,
+		compressionAlgo: compressionAlgo,
+		compressionOK:   compressionOK,
+	}, nil
+}
+
+// writeHello msgpack-encodes h, prefixed with the hello type/version
+// header used by every frame on this connection.
+func writeHello(conn net.Conn, h *hello) error {
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.Write([]byte{byte(helloMsg), byte(messageTypeVersions[helloMsg])}); err != nil {
+		return err
+	}
+	enc := codec.NewEncoder(buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+// readHello reads and decodes the other side's hello frame off bufConn.
+func readHello(bufConn *bufio.Reader) (hello, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(bufConn, buf[:]); err != nil {
+		return hello{}, err
+	}
+	if messageType(buf[0]) != helloMsg {
+		return hello{}, fmt.Errorf("expected hello message, got type %d", buf[0])
+	}
+
+	// This is the first frame on a brand-new connection, before any
+	// negotiation has happened, so there's no negotiated max message size
+	// to check a decoded field against the way readRemoteState bounds
+	// pushPullHeader. Cap the raw bytes the decoder can read instead.
+	var h hello
+	dec := codec.NewDecoder(io.LimitReader(bufConn, helloMaxMsgSize), &codec.MsgpackHandle{})
+	if err := dec.Decode(&h); err != nil {
+		return hello{}, err
+	}
+	return h, nil
+}
+
+// acceptableVersion reports whether msgVersion is acceptable for msgType
+// on this connection: if the hello handshake negotiated a version for
+// it, msgVersion must be no newer than that; otherwise it falls back to
+// validVersion's ±1 rule.
+func acceptableVersion(versions map[messageType]messageVersion, msgType messageType, msgVersion messageVersion) bool {
+	if v, ok := versions[msgType]; ok {
+		return msgVersion <= v
+	}
+	return validVersion(msgType, msgVersion)
+}
+
+// sendLocalState is invoked to send our local state over a tcp connection.
+// hs is whatever exchangeHello negotiated for this connection.
+func (m *Memberlist) sendLocalState(conn net.Conn, hs negotiatedHello) error {
 	// Prepare the local node state
 	m.nodeLock.RLock()
 	localNodes := make([]pushNodeState, len(m.nodes))
@@ -492,7 +803,7 @@ func (m *Memberlist) sendLocalState(conn net.Conn) error {
 
 	// Begin state push
 	if _, err := bufConn.Write([]byte{
-		byte(pushPullMsg), byte(messageTypeVersions[pushPullMsg])}); err != nil {
+		byte(pushPullMsg), byte(hs.versions[pushPullMsg])}); err != nil {
 		return err
 	}
 
@@ -515,9 +826,12 @@ func (m *Memberlist) sendLocalState(conn net.Conn) error {
 	// Get the send buffer
 	sendBuf := bufConn.Bytes()
 
-	// Check if compresion is enabled
-	if m.config.EnableCompression {
-		compBuf, err := compressPayload(bufConn.Bytes())
+	// Check if compresion is enabled. Only use it if the hello handshake
+	// found an algorithm both sides can decode; a peer with no overlap
+	// with our supported algorithms gets an uncompressed stream instead
+	// of a compressMsg frame it couldn't read.
+	if m.config.EnableCompression && hs.compressionOK && len(sendBuf) >= m.config.MinCompressSize {
+		compBuf, err := compressPayload(bufConn.Bytes(), hs.compressionAlgo)
 		if err != nil {
 			m.logger.Printf("[ERROR] Failed to compress local state: %v", err)
 		} else {
@@ -525,6 +839,31 @@ func (m *Memberlist) sendLocalState(conn net.Conn) error {
 		}
 	}
 
+	// Check if encryption is enabled. Unlike UDP, the encrypted blob's
+	// length isn't implied by the length of the packet, so we prefix it
+	// with a 4-byte length the other side can use to slurp exactly the
+	// encrypted bytes before handing the plaintext to the msgpack decoder.
+	if m.config.EncryptionEnabled() {
+		crypt, err := encryptPayload(m.config.Keyring.GetPrimaryKey(), sendBuf)
+		if err != nil {
+			m.logger.Printf("[ERR] Failed to encrypt local state: %v", err)
+			return err
+		}
+
+		header := make([]byte, 6)
+		header[0] = byte(encryptMsg)
+		header[1] = byte(hs.versions[encryptMsg])
+		binary.BigEndian.PutUint32(header[2:], uint32(len(crypt)))
+
+		if _, err := conn.Write(header); err != nil {
+			return err
+		}
+		if _, err := conn.Write(crypt); err != nil {
+			return err
+		}
+		return nil
+	}
+
 	// Write out the entire send buffer
 	if _, err := conn.Write(sendBuf); err != nil {
 		return err
@@ -532,21 +871,66 @@ func (m *Memberlist) sendLocalState(conn net.Conn) error {
 	return nil
 }
 
-// recvRemoteState is used to read the remote state from a connection
-func readRemoteState(conn net.Conn) ([]pushNodeState, []byte, error) {
-	// Created a buffered reader
-	var bufConn io.Reader = bufio.NewReader(conn)
-
+// readRemoteState is used to read the remote state from a connection.
+// bufConn must be the same buffered reader exchangeHello read the hello
+// frame from, so nothing it already pulled off the socket is lost. The
+// push/pull header's claimed node count and user state length, and the
+// encrypted-frame length prefix, are checked against hs.maxMsgSize (the
+// smaller of the two sides' advertised limits) before being trusted for
+// an allocation.
+func (m *Memberlist) readRemoteState(bufConn io.Reader, hs negotiatedHello) ([]pushNodeState, []byte, error) {
 	// Read the message type
 	buf := [2]byte{0, 0}
-	if _, err := conn.Read(buf[:]); err != nil {
+	if _, err := io.ReadFull(bufConn, buf[:]); err != nil {
 		return nil, nil, err
 	}
 	msgType := messageType(buf[0])
 	msgVersion := messageVersion(buf[1])
 
+	// Enforce the encryption policy on the stream, mirroring the UDP
+	// path in handleCommand.
+	if msgType == encryptMsg {
+		if !m.config.EncryptionEnabled() {
+			return nil, nil, fmt.Errorf("remote state is encrypted but no keyring is configured")
+		}
+		if !acceptableVersion(hs.versions, encryptMsg, msgVersion) {
+			return nil, nil, fmt.Errorf("[ERR] Received encrypted wrapper with a bad version: %d", msgVersion)
+		}
+
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(bufConn, lenBuf[:]); err != nil {
+			return nil, nil, err
+		}
+		encLen := binary.BigEndian.Uint32(lenBuf[:])
+		if encLen > uint32(hs.maxMsgSize) {
+			return nil, nil, fmt.Errorf("remote encrypted state of %d bytes exceeds negotiated max message size of %d bytes", encLen, hs.maxMsgSize)
+		}
+
+		enc := make([]byte, encLen)
+		if _, err := io.ReadFull(bufConn, enc); err != nil {
+			return nil, nil, err
+		}
+
+		plain, err := decryptPayload(m.config.Keyring.GetKeys(), enc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(plain) < 2 {
+			return nil, nil, fmt.Errorf("decrypted remote state is too short (%d bytes)", len(plain))
+		}
+
+		// The decrypted plaintext is itself a full message (type,
+		// version and body), so unwrap it the same way a compressed
+		// one is unwrapped below.
+		msgType = messageType(plain[0])
+		msgVersion = messageVersion(plain[1])
+		bufConn = bytes.NewReader(plain[2:])
+	} else if m.config.EncryptionEnabled() && m.config.GossipVerifyIncomingData {
+		return nil, nil, fmt.Errorf("encryption is required but remote state was sent in cleartext")
+	}
+
 	// Verify that we can understand this PP request
-	if !validVersion(msgType, msgVersion) {
+	if !acceptableVersion(hs.versions, msgType, msgVersion) {
 		return nil, nil, fmt.Errorf("[ERR] Received PP request with a bad version: %d", msgVersion)
 	}
 
@@ -564,6 +948,9 @@ func readRemoteState(conn net.Conn) ([]pushNodeState, []byte, error) {
 		if err != nil {
 			return nil, nil, err
 		}
+		if len(decomp) < 2 {
+			return nil, nil, fmt.Errorf("decompressed remote state is too short (%d bytes)", len(decomp))
+		}
 
 		// Reset the message type
 		msgType = messageType(decomp[0])
@@ -583,7 +970,7 @@ func readRemoteState(conn net.Conn) ([]pushNodeState, []byte, error) {
 	}
 
 	// Verify that we can understand this PP request
-	if !validVersion(msgType, msgVersion) {
+	if !acceptableVersion(hs.versions, msgType, msgVersion) {
 		return nil, nil, fmt.Errorf("[ERR] Received PP request with a bad version: %d", msgVersion)
 	}
 
@@ -593,6 +980,16 @@ func readRemoteState(conn net.Conn) ([]pushNodeState, []byte, error) {
 		return nil, nil, err
 	}
 
+	// Bound both claimed sizes against the negotiated max before trusting
+	// them for an allocation. UserStateLen is already a byte count;
+	// header.Nodes is a count of structs, so it's capped via
+	// minPushNodeStateSize rather than compared to maxMsgSize directly.
+	maxNodes := hs.maxMsgSize / minPushNodeStateSize
+	if header.Nodes < 0 || header.UserStateLen < 0 ||
+		header.Nodes > maxNodes || header.UserStateLen > hs.maxMsgSize {
+		return nil, nil, fmt.Errorf("remote pushPullHeader exceeds negotiated max message size of %d bytes", hs.maxMsgSize)
+	}
+
 	// Allocate space for the transfer
 	remoteNodes := make([]pushNodeState, header.Nodes)
 
@@ -607,13 +1004,7 @@ func readRemoteState(conn net.Conn) ([]pushNodeState, []byte, error) {
 	var userBuf []byte
 	if header.UserStateLen > 0 {
 		userBuf = make([]byte, header.UserStateLen)
-		bytes, err := bufConn.Read(userBuf)
-		if err == nil && bytes != header.UserStateLen {
-			err = fmt.Errorf(
-				"Failed to read full user state (%d / %d)",
-				bytes, header.UserStateLen)
-		}
-		if err != nil {
+		if _, err := io.ReadFull(bufConn, userBuf); err != nil {
 			return remoteNodes, nil, err
 		}
 	}
@@ -0,0 +1,70 @@
+package memberlist
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"testing"
+)
+
+func testMemberlistForHello(compressionAlgo compressionType) *Memberlist {
+	return &Memberlist{
+		config: &Config{
+			CompressionAlgo: compressionAlgo,
+		},
+		logger: log.New(io.Discard, "", 0),
+	}
+}
+
+// TestExchangeHello_Negotiation covers the version/compression negotiation
+// exchangeHello performs over a real connection, between two peers that
+// are configured with different compression algorithms.
+func TestExchangeHello_Negotiation(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := testMemberlistForHello(zstdAlgo)
+	server := testMemberlistForHello(lz4Algo)
+
+	type result struct {
+		hs  negotiatedHello
+		err error
+	}
+	clientResult := make(chan result, 1)
+	serverResult := make(chan result, 1)
+
+	go func() {
+		hs, err := client.exchangeHello(clientConn, bufio.NewReader(clientConn), true)
+		clientResult <- result{hs, err}
+	}()
+	go func() {
+		hs, err := server.exchangeHello(serverConn, bufio.NewReader(serverConn), false)
+		serverResult <- result{hs, err}
+	}()
+
+	cr := <-clientResult
+	sr := <-serverResult
+
+	if cr.err != nil {
+		t.Fatalf("client err: %v", cr.err)
+	}
+	if sr.err != nil {
+		t.Fatalf("server err: %v", sr.err)
+	}
+
+	// Both sides advertise the full supportedCompressionAlgos list, so each
+	// one can decode the other's configured algorithm and keeps its own
+	// for what it sends, rather than disabling compression.
+	if !cr.hs.compressionOK || !sr.hs.compressionOK {
+		t.Fatalf("expected both sides to enable compression: client=%+v server=%+v", cr.hs, sr.hs)
+	}
+	if cr.hs.compressionAlgo != zstdAlgo || sr.hs.compressionAlgo != lz4Algo {
+		t.Fatalf("expected each side to keep its configured algorithm: client=%v server=%v", cr.hs.compressionAlgo, sr.hs.compressionAlgo)
+	}
+
+	if cr.hs.maxMsgSize != helloMaxMsgSize || sr.hs.maxMsgSize != helloMaxMsgSize {
+		t.Fatalf("expected both sides to negotiate the shared MaxMsgSize, got client=%d server=%d", cr.hs.maxMsgSize, sr.hs.maxMsgSize)
+	}
+}
@@ -0,0 +1,369 @@
+package memberlist
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// udpRecvBatchSize is the number of datagrams (or GRO-coalesced bursts)
+// udpReadLoopBatch asks the kernel for in a single recvmmsg(2) call.
+const udpRecvBatchSize = 8
+
+// minPacketSize is the smallest datagram handleCommand can safely look
+// at: it unconditionally indexes buf[0] and buf[1] for the message type
+// and version before doing anything else.
+const minPacketSize = 2
+
+// NetTransportConfig is used to configure a NetTransport.
+type NetTransportConfig struct {
+	// BindAddr is the address to bind to for both the UDP and TCP
+	// listeners.
+	BindAddr string
+
+	// UDPPort is the port to listen on for UDP packets (pings, acks,
+	// indirect pings, gossip).
+	UDPPort int
+
+	// TCPPort is the port to listen on for TCP push/pull syncs.
+	TCPPort int
+
+	// Logger is used for log messages from the transport. If not
+	// provided, a default logger writing to stderr is used.
+	Logger *log.Logger
+
+	// EnableUDPOffload turns on Linux UDP GSO/GRO batching for the packet
+	// path: outgoing fan-outs are coalesced into a single sendmmsg(2)
+	// call and incoming bursts are read back with recvmmsg(2)/UDP_GRO.
+	// It is probed once at startup and silently has no effect on
+	// platforms or kernels that don't support it.
+	EnableUDPOffload bool
+}
+
+// NetTransport is a Transport implementation that uses connectionless UDP
+// for packet operations and connection-oriented TCP for stream operations.
+// This is the original transport memberlist has always used, now exposed
+// behind the Transport interface so it can be swapped out.
+type NetTransport struct {
+	config   *NetTransportConfig
+	logger   *log.Logger
+	packetCh chan *Packet
+	streamCh chan net.Conn
+	udpConn  *net.UDPConn
+	tcpLn    *net.TCPListener
+	offload  *udpOffload
+}
+
+// NewNetTransport creates a NetTransport, binding the UDP and TCP
+// listeners described by config and starting the goroutines that feed
+// PacketCh and StreamCh.
+func NewNetTransport(config *NetTransportConfig) (*NetTransport, error) {
+	udpAddr := &net.UDPAddr{IP: net.ParseIP(config.BindAddr), Port: config.UDPPort}
+	udpLn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start UDP listener on %q port %d: %v", config.BindAddr, config.UDPPort, err)
+	}
+	setUDPRecvBuf(udpLn)
+
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP(config.BindAddr), Port: config.TCPPort}
+	tcpLn, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		udpLn.Close()
+		return nil, fmt.Errorf("failed to start TCP listener on %q port %d: %v", config.BindAddr, config.TCPPort, err)
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	t := &NetTransport{
+		config:   config,
+		logger:   logger,
+		packetCh: make(chan *Packet),
+		streamCh: make(chan net.Conn),
+		udpConn:  udpLn,
+		tcpLn:    tcpLn,
+		offload:  newUDPOffload(udpLn, config.EnableUDPOffload),
+	}
+
+	go t.tcpAcceptLoop()
+	go t.udpReadLoop()
+	return t, nil
+}
+
+// newNetTransportFromConfig builds a NetTransport out of the legacy
+// Config.BindAddr/UDPPort/TCPPort fields. This is the shim that lets
+// existing callers who never touch Config.Transport keep working exactly
+// as before.
+func newNetTransportFromConfig(config *Config) (*NetTransport, error) {
+	nc := &NetTransportConfig{
+		BindAddr:         config.BindAddr,
+		UDPPort:          config.UDPPort,
+		TCPPort:          config.TCPPort,
+		Logger:           config.Logger,
+		EnableUDPOffload: config.EnableUDPOffload,
+	}
+	return NewNetTransport(nc)
+}
+
+// setUDPRecvBuf is used to resize the UDP receive window. The function
+// attempts to set the read buffer to `udpRecvBuf` but backs off until the
+// read buffer can be set.
+func setUDPRecvBuf(c *net.UDPConn) {
+	size := udpRecvBuf
+	for {
+		if err := c.SetReadBuffer(size); err == nil {
+			break
+		}
+		size = size / 2
+	}
+}
+
+// tcpAcceptLoop accepts incoming push/pull connections and feeds them to
+// StreamCh. A run of AcceptTCP errors (e.g. fd exhaustion) backs off
+// exponentially instead of spinning the CPU and the logger.
+func (t *NetTransport) tcpAcceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := t.tcpLn.AcceptTCP()
+		if err != nil {
+			if isShutdownError(err) {
+				break
+			}
+			t.logger.Printf("[ERR] memberlist: Error accepting TCP connection: %v", err)
+			delay = nextBackoff(delay, err, tcpAcceptBackoffBase, tcpAcceptBackoffTempMax, tcpAcceptBackoffPermMax)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		t.streamCh <- conn
+	}
+	close(t.streamCh)
+}
+
+// udpReadLoop reads incoming packets and feeds them to PacketCh. When UDP
+// offload is enabled it defers to udpReadLoopBatch, which reads several
+// (possibly GRO-coalesced) datagrams per recvmmsg(2) call.
+func (t *NetTransport) udpReadLoop() {
+	if t.offload.enabled() {
+		t.udpReadLoopBatch()
+		return
+	}
+	t.udpReadLoopSingle()
+}
+
+// udpReadLoopSingle is the plain one-packet-per-ReadFrom loop used when
+// UDP offload is disabled or unsupported. A run of ReadFrom errors backs
+// off exponentially, the same as tcpAcceptLoop but with a shorter cap
+// since a stalled packet path is more disruptive than a stalled accept.
+func (t *NetTransport) udpReadLoopSingle() {
+	buf := make([]byte, udpBufSize)
+	var delay time.Duration
+	for {
+		n, addr, err := t.udpConn.ReadFrom(buf)
+		if err != nil {
+			if isShutdownError(err) {
+				break
+			}
+			t.logger.Printf("[ERR] memberlist: Error reading UDP packet: %v", err)
+			delay = nextBackoff(delay, err, udpReadBackoffBase, udpReadBackoffTempMax, udpReadBackoffPermMax)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if n < minPacketSize {
+			t.logger.Printf("[ERR] memberlist: UDP packet too short (%d bytes) from %s", n, addr)
+			continue
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		t.packetCh <- &Packet{Buf: msg, From: addr, Timestamp: time.Now()}
+	}
+	close(t.packetCh)
+}
+
+// udpReadLoopBatch reads up to udpRecvBatchSize datagrams, or GRO-coalesced
+// bursts already split back into individual messages, per recvmmsg(2)
+// call. A read error that means the kernel doesn't actually support
+// UDP_GRO permanently disables offload and hands off to
+// udpReadLoopSingle instead of failing the listener outright.
+func (t *NetTransport) udpReadLoopBatch() {
+	bufs := make([][]byte, udpRecvBatchSize)
+	for i := range bufs {
+		bufs[i] = make([]byte, udpBufSize)
+	}
+	for {
+		packets, err := t.offload.recvBatch(bufs)
+		if err != nil {
+			if isShutdownError(err) {
+				close(t.packetCh)
+				return
+			}
+			t.logger.Printf("[WARN] memberlist: UDP_GRO read failed, disabling UDP offload: %v", err)
+			t.offload.disable()
+			t.udpReadLoopSingle()
+			return
+		}
+		for _, p := range packets {
+			if len(p.Buf) < minPacketSize {
+				t.logger.Printf("[ERR] memberlist: UDP packet too short (%d bytes) from %s", len(p.Buf), p.From)
+				continue
+			}
+			t.packetCh <- p
+		}
+	}
+}
+
+// WriteTo implements Transport.
+func (t *NetTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = t.udpConn.WriteTo(b, udpAddr)
+	return time.Now(), err
+}
+
+// WriteToBatch implements BatchTransport. When UDP offload is enabled and
+// msgs are all the same length, the whole batch goes out in a single
+// sendmmsg(2) call; otherwise, once a send has come back EIO, or for
+// whatever tail of the batch sendmmsg(2) didn't actually queue, it falls
+// back to one WriteTo per remaining destination.
+func (t *NetTransport) WriteToBatch(msgs [][]byte, addrs []string) (time.Time, error) {
+	if t.offload.enabled() && sameLength(msgs) {
+		udpAddrs := make([]*net.UDPAddr, len(addrs))
+		for i, a := range addrs {
+			udpAddr, err := net.ResolveUDPAddr("udp", a)
+			if err != nil {
+				return time.Time{}, err
+			}
+			udpAddrs[i] = udpAddr
+		}
+
+		n, err := t.offload.sendBatch(msgs, udpAddrs, len(msgs[0]))
+		if err != nil && t.offload.isFatal(err) {
+			t.offload.disable()
+		}
+		if err == nil && n >= len(msgs) {
+			return time.Now(), nil
+		}
+		if n > 0 {
+			// Part of the batch was already sent; only retry the unsent
+			// tail below instead of resending everything.
+			msgs = msgs[n:]
+			addrs = addrs[n:]
+		}
+		// Fall through to the per-destination path below.
+	}
+
+	var firstErr error
+	for i, msg := range msgs {
+		if _, err := t.WriteTo(msg, addrs[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	t.offload.addUnbatched(len(msgs))
+	return time.Now(), firstErr
+}
+
+// sameLength reports whether every message in msgs has the same length,
+// which UDP_SEGMENT requires of a batch (other than a shorter final
+// message).
+func sameLength(msgs [][]byte) bool {
+	if len(msgs) == 0 {
+		return false
+	}
+	n := len(msgs[0])
+	for _, m := range msgs[1:] {
+		if len(m) != n {
+			return false
+		}
+	}
+	return true
+}
+
+// CoalescedPacketCount returns the number of packets sent via a batched
+// sendmmsg(2) call or received as part of a GRO-coalesced burst since the
+// transport was created. It is always 0 when UDP offload is disabled or
+// unsupported, so operators can use it to measure the win on high-fanout
+// clusters.
+func (t *NetTransport) CoalescedPacketCount() uint64 { return t.offload.coalescedCount() }
+
+// UnbatchedPacketCount returns the number of packets WriteToBatch sent one
+// at a time through its per-destination fallback, plus the datagrams
+// udpReadLoopBatch read that were not part of a GRO-coalesced burst.
+// Packets sent with WriteTo or read by udpReadLoopSingle are not counted,
+// and it is always 0 on platforms other than Linux.
+func (t *NetTransport) UnbatchedPacketCount() uint64 { return t.offload.unbatchedCount() }
+
+// PacketCh implements Transport.
+func (t *NetTransport) PacketCh() <-chan *Packet {
+	return t.packetCh
+}
+
+// DialTimeout implements Transport.
+func (t *NetTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	return dialer.Dial("tcp", addr)
+}
+
+// StreamCh implements Transport.
+func (t *NetTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown implements Transport.
+func (t *NetTransport) Shutdown() error {
+	t.tcpLn.Close()
+	t.udpConn.Close()
+	return nil
+}
+
+// isShutdownError returns true for the errors Accept/ReadFrom return once
+// the underlying listener has been closed out from under them, which we
+// expect during a normal Shutdown and don't want to log as a failure.
+func isShutdownError(err error) bool {
+	const closedMsg = "use of closed network connection"
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Err != nil && opErr.Err.Error() == closedMsg
+	}
+	return false
+}
+
+// Backoff bounds for tcpAcceptLoop and udpReadLoopSingle: both start at
+// the same base delay and double on each consecutive error, but UDP caps
+// lower since a stalled packet path is more disruptive than a stalled
+// accept loop.
+const (
+	tcpAcceptBackoffBase    = 5 * time.Millisecond
+	tcpAcceptBackoffTempMax = 1 * time.Second
+	tcpAcceptBackoffPermMax = 5 * time.Second
+
+	udpReadBackoffBase    = 5 * time.Millisecond
+	udpReadBackoffTempMax = 250 * time.Millisecond
+	udpReadBackoffPermMax = 1 * time.Second
+)
+
+// nextBackoff doubles delay (or starts it at base if it's zero), capping
+// the result at tempMax when err is a temporary net.Error and at the
+// wider permMax otherwise.
+func nextBackoff(delay time.Duration, err error, base, tempMax, permMax time.Duration) time.Duration {
+	if delay == 0 {
+		delay = base
+	} else {
+		delay *= 2
+	}
+
+	max := permMax
+	if ne, ok := err.(net.Error); ok && ne.Temporary() {
+		max = tempMax
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
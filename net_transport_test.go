@@ -0,0 +1,147 @@
+package memberlist
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer wraps a bytes.Buffer with a mutex so it can be safely written
+// by the packetListen goroutine and read by the test goroutine at the same
+// time.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestNextBackoff(t *testing.T) {
+	const (
+		base    = 5 * time.Millisecond
+		tempMax = 250 * time.Millisecond
+		permMax = 1 * time.Second
+	)
+
+	tempErr := &net.OpError{Err: errTemporary{}}
+	permErr := errors.New("permanent failure")
+
+	tests := []struct {
+		name  string
+		delay time.Duration
+		err   error
+		want  time.Duration
+	}{
+		{"starts at base", 0, permErr, base},
+		{"doubles on a permanent error", base, permErr, 2 * base},
+		{"doubles on a temporary error", base, tempErr, 2 * base},
+		{"caps at tempMax for a temporary error", tempMax, tempErr, tempMax},
+		{"exceeds tempMax but stays under permMax for a permanent error", tempMax, permErr, 2 * tempMax},
+		{"caps at permMax for a permanent error", permMax, permErr, permMax},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextBackoff(tt.delay, tt.err, base, tempMax, permMax)
+			if got != tt.want {
+				t.Fatalf("nextBackoff(%v, %v) = %v, want %v", tt.delay, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// errTemporary is a net.Error that reports itself as temporary, for driving
+// nextBackoff's tempMax branch.
+type errTemporary struct{}
+
+func (errTemporary) Error() string   { return "temporary error" }
+func (errTemporary) Timeout() bool   { return true }
+func (errTemporary) Temporary() bool { return true }
+
+// fakeTransport is a minimal in-memory Transport, standing in for the real
+// UDP/TCP sockets NetTransport wraps, to drive packetListen's dispatch path
+// without a network.
+type fakeTransport struct {
+	packetCh chan *Packet
+	streamCh chan net.Conn
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		packetCh: make(chan *Packet),
+		streamCh: make(chan net.Conn),
+	}
+}
+
+func (f *fakeTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	return time.Now(), nil
+}
+func (f *fakeTransport) PacketCh() <-chan *Packet  { return f.packetCh }
+func (f *fakeTransport) StreamCh() <-chan net.Conn { return f.streamCh }
+func (f *fakeTransport) Shutdown() error {
+	close(f.packetCh)
+	close(f.streamCh)
+	return nil
+}
+func (f *fakeTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return nil, errors.New("fakeTransport does not support dialing")
+}
+
+// TestPacketListen_DispatchesThroughFakeTransport confirms packetListen
+// reads packets off whatever Transport it's given, not just a real
+// NetTransport, and hands each one to handleCommand.
+func TestPacketListen_DispatchesThroughFakeTransport(t *testing.T) {
+	var logBuf syncBuffer
+	transport := newFakeTransport()
+	m := &Memberlist{
+		config:    &Config{},
+		transport: transport,
+		logger:    log.New(&logBuf, "", 0),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.packetListen()
+		close(done)
+	}()
+
+	// msgType 200 isn't one handleCommand's switch recognizes, so it falls
+	// through to the default case and logs rather than silently dropping
+	// the packet or panicking, confirming the packet actually made it
+	// through packetListen's loop and into handleCommand.
+	transport.packetCh <- &Packet{
+		Buf:       []byte{200, 0},
+		From:      &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 7946},
+		Timestamp: time.Now(),
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		if strings.Contains(logBuf.String(), "not supported") {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("handleCommand never logged the unsupported message type; got: %q", logBuf.String())
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	transport.Shutdown()
+	<-done
+}
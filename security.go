@@ -0,0 +1,84 @@
+package memberlist
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// gcmNonceSize is the size of the random nonce AES-GCM expects. It is
+// generated fresh for every message and prepended to the ciphertext so
+// the receiver never has to agree on it out of band.
+const gcmNonceSize = 12
+
+// gcmTagSize is the size of the authentication tag GCM appends to the
+// ciphertext.
+const gcmTagSize = 16
+
+// encryptOverhead returns the maximum number of bytes encryptPayload adds
+// on top of the plaintext, for callers sizing buffers ahead of time.
+func encryptOverhead() int {
+	return gcmNonceSize + gcmTagSize
+}
+
+// encryptPayload encrypts msg with the given AES key using GCM. The
+// returned buffer is the random nonce followed by the ciphertext and
+// authentication tag.
+func encryptPayload(key []byte, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(nonce), len(nonce)+len(msg)+gcm.Overhead())
+	copy(out, nonce)
+	return gcm.Seal(out, nonce, msg, nil), nil
+}
+
+// decryptPayload decrypts msg, trying each key in the ring in order until
+// one of them validates. This is what lets operators roll a key online:
+// the new key only has to be installed as a non-primary key on a node
+// before its peers start encrypting with it.
+func decryptPayload(keys [][]byte, msg []byte) ([]byte, error) {
+	if len(msg) < gcmNonceSize {
+		return nil, fmt.Errorf("cannot decrypt, message too small (%d bytes)", len(msg))
+	}
+	nonce := msg[:gcmNonceSize]
+	ciphertext := msg[gcmNonceSize:]
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no installed keys could decrypt the message")
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return plain, nil
+	}
+	return nil, fmt.Errorf("no installed keys could decrypt the message: %v", lastErr)
+}
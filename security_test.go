@@ -0,0 +1,76 @@
+package memberlist
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyring_EncryptDecrypt_AfterRotation(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	for i := range oldKey {
+		oldKey[i] = byte(i)
+	}
+	for i := range newKey {
+		newKey[i] = byte(i + 1)
+	}
+
+	keyring, err := NewKeyring(nil, oldKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	msg := []byte("the quick brown fox")
+	enc, err := encryptPayload(keyring.GetPrimaryKey(), msg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Install the new key and promote it to primary, simulating a live
+	// rotation. The old key is still installed (not yet removed), so a
+	// message encrypted before the rotation must still decrypt.
+	if err := keyring.AddKey(newKey); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := keyring.UseKey(newKey); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	plain, err := decryptPayload(keyring.GetKeys(), enc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(plain, msg) {
+		t.Fatalf("bad: %v", plain)
+	}
+
+	// New messages encrypted with the now-primary key must also decrypt,
+	// without needing to touch the old one.
+	enc2, err := encryptPayload(keyring.GetPrimaryKey(), msg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	plain2, err := decryptPayload(keyring.GetKeys(), enc2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(plain2, msg) {
+		t.Fatalf("bad: %v", plain2)
+	}
+
+	// Once the old key is removed, messages encrypted with it must no
+	// longer decrypt.
+	if err := keyring.RemoveKey(oldKey); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := decryptPayload(keyring.GetKeys(), enc); err == nil {
+		t.Fatalf("expected decrypt to fail after removing the key it was encrypted with")
+	}
+}
+
+func TestDecryptPayload_TooShort(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := decryptPayload([][]byte{key}, []byte("x")); err == nil {
+		t.Fatalf("expected error for undersized message")
+	}
+}
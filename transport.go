@@ -0,0 +1,70 @@
+package memberlist
+
+import (
+	"net"
+	"time"
+)
+
+// Packet is used to provide some metadata about incoming packets from
+// peers over a packet-oriented transport, as well as the packet payload.
+type Packet struct {
+	// Buf has the raw contents of the packet.
+	Buf []byte
+
+	// From has the address of the peer this packet came from. This is an
+	// actual net.Addr so transports can expose concrete details (e.g. a
+	// UDP source port) about incoming packets.
+	From net.Addr
+
+	// Timestamp is the time the transport received the packet.
+	Timestamp time.Time
+}
+
+// Transport is used to abstract over the mechanism memberlist uses to
+// talk to other peers. The SWIM logic only depends on this interface, so
+// a packet-oriented and a stream-oriented carrier (UDP+TCP sockets, an
+// in-memory transport for tests, a TLS-wrapped stream, a mesh overlay)
+// can all be swapped in without touching net.go.
+//
+// The packet side is assumed to be best-effort: lost or reordered
+// packets are tolerated by the SWIM protocol. The stream side is assumed
+// to be reliable and ordered, the way push/pull state transfer needs.
+type Transport interface {
+	// WriteTo is a packet-oriented interface that fires off the given
+	// payload to the given address in a best-effort fashion. It returns a
+	// timestamp for when the message was actually written, which may be
+	// used to correct the measured RTT by subtracting time spent waiting
+	// for the send.
+	WriteTo(b []byte, addr string) (time.Time, error)
+
+	// PacketCh returns a channel that can be read to receive incoming
+	// packets from other peers. It is closed when the transport is shut
+	// down.
+	PacketCh() <-chan *Packet
+
+	// DialTimeout is used to create a connection that allows us to
+	// perform two-way communication with a peer, such as during push/pull
+	// state transfer.
+	DialTimeout(addr string, timeout time.Duration) (net.Conn, error)
+
+	// StreamCh returns a channel that can be read to handle incoming
+	// stream connections from other peers. It is closed when the
+	// transport is shut down.
+	StreamCh() <-chan net.Conn
+
+	// Shutdown is called when memberlist is shutting down; this gives the
+	// transport a chance to clean up any listeners.
+	Shutdown() error
+}
+
+// BatchTransport is an optional capability a packet-oriented Transport can
+// implement to fan the same or similarly-sized payload out to several
+// destinations in one syscall (e.g. Linux sendmmsg(2) with UDP_SEGMENT)
+// instead of one WriteTo call per destination. memberlist reaches for this
+// on broadcast rounds and indirect-ping fan-out; callers type-assert for
+// it and fall back to plain WriteTo when a transport doesn't implement it.
+type BatchTransport interface {
+	// WriteToBatch is WriteTo generalized to many destinations. len(msgs)
+	// must equal len(addrs).
+	WriteToBatch(msgs [][]byte, addrs []string) (time.Time, error)
+}
@@ -0,0 +1,219 @@
+//go:build linux
+// +build linux
+
+package memberlist
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+// udpOffload implements Linux UDP segmentation offload: GSO (UDP_SEGMENT)
+// batches many equally-sized outgoing datagrams into a single
+// sendmmsg(2) call, and GRO (UDP_GRO) lets the kernel hand back a
+// coalesced burst from a single recvmmsg(2) call, which we split back
+// into individual messages before dispatch. Support is probed once at
+// startup with a real loopback send rather than trusting that the
+// sockopt exists; the first EIO a send returns afterwards permanently
+// disables GSO for this socket, so a NIC that lies about support only
+// costs one failed syscall rather than one per broadcast round.
+type udpOffload struct {
+	pc *ipv4.PacketConn
+
+	disabled  uint32 // atomic bool: 1 once a send has come back EIO
+	coalesced uint64 // atomic counter: packets sent via sendmmsg or received in a GRO burst
+	unbatched uint64 // atomic counter: packets sent/received one at a time
+}
+
+// probeDrainTimeout bounds how long probe() waits to read its own GSO
+// probe datagram back off the socket before giving up on draining it.
+const probeDrainTimeout = 50 * time.Millisecond
+
+func newUDPOffload(conn *net.UDPConn, enable bool) *udpOffload {
+	o := &udpOffload{pc: ipv4.NewPacketConn(conn)}
+	if !enable {
+		atomic.StoreUint32(&o.disabled, 1)
+		return o
+	}
+	if !enableUDPGRO(conn) || !o.probe() {
+		atomic.StoreUint32(&o.disabled, 1)
+	}
+	return o
+}
+
+// enableUDPGRO sets the UDP_GRO socket option so the kernel coalesces
+// incoming datagrams from the same source into a single recvmmsg(2)
+// message instead of one at a time. Without it, parseGROSegmentSize
+// never sees a UDP_GRO control message and recvBatch falls back to
+// reading one packet per message regardless of what the NIC can do.
+func enableUDPGRO(conn *net.UDPConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1)
+	}); err != nil {
+		return false
+	}
+	return sockErr == nil
+}
+
+// probe sends a two-segment GSO packet to ourselves; if the kernel or the
+// NIC doesn't really support UDP_SEGMENT this comes back as an error
+// rather than succeeding and silently corrupting the first real send.
+// It runs before the read loop goroutines start, so it also drains its
+// own probe datagram back off the socket rather than leaving it for
+// udpReadLoop to deliver to handleCommand as a bogus incoming packet.
+func (o *udpOffload) probe() bool {
+	local, ok := o.pc.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	msg := ipv4.Message{
+		Buffers: [][]byte{make([]byte, 4)},
+		Addr:    local,
+		OOB:     gsoControlMessage(2),
+	}
+	_, err := o.pc.WriteBatch([]ipv4.Message{msg}, 0)
+	if err != nil {
+		return false
+	}
+
+	o.pc.SetReadDeadline(time.Now().Add(probeDrainTimeout))
+	drain := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		if _, _, err := o.pc.ReadFrom(drain); err != nil {
+			break
+		}
+	}
+	o.pc.SetReadDeadline(time.Time{})
+	return true
+}
+
+func (o *udpOffload) enabled() bool {
+	return atomic.LoadUint32(&o.disabled) == 0
+}
+
+func (o *udpOffload) disable() {
+	atomic.StoreUint32(&o.disabled, 1)
+}
+
+// isFatal reports whether err is the sendmmsg(2) failure mode that means
+// GSO doesn't actually work on this socket, as opposed to an ordinary
+// per-destination send error. WriteBatch wraps the raw errno in
+// *net.OpError/os.SyscallError, so this unwraps rather than comparing
+// directly.
+func (o *udpOffload) isFatal(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
+
+func (o *udpOffload) coalescedCount() uint64 { return atomic.LoadUint64(&o.coalesced) }
+func (o *udpOffload) unbatchedCount() uint64 { return atomic.LoadUint64(&o.unbatched) }
+func (o *udpOffload) addUnbatched(n int)     { atomic.AddUint64(&o.unbatched, uint64(n)) }
+
+// sendBatch sends msgs, all of which must be segSize bytes except
+// possibly the last, to the corresponding addrs in a single sendmmsg(2)
+// call via UDP_SEGMENT. The caller is expected to check enabled() first;
+// sendBatch just reports the raw error so the caller can decide whether
+// to disable and fall back. It returns the number of messages the kernel
+// actually queued: sendmmsg(2) can return success with n < len(msgs) when
+// one destination partway through the batch fails (e.g. unreachable),
+// so the caller must check n rather than assume a nil error means every
+// message went out.
+func (o *udpOffload) sendBatch(msgs [][]byte, addrs []*net.UDPAddr, segSize int) (int, error) {
+	wms := make([]ipv4.Message, len(msgs))
+	for i, msg := range msgs {
+		wms[i] = ipv4.Message{
+			Buffers: [][]byte{msg},
+			Addr:    addrs[i],
+			OOB:     gsoControlMessage(segSize),
+		}
+	}
+
+	n, err := o.pc.WriteBatch(wms, 0)
+	atomic.AddUint64(&o.coalesced, uint64(n))
+	return n, err
+}
+
+// recvBatch reads up to len(bufs) datagrams (or coalesced GRO bursts,
+// each split back into segSize-sized pieces) in a single recvmmsg(2)
+// call and returns one Packet per individual message.
+func (o *udpOffload) recvBatch(bufs [][]byte) ([]*Packet, error) {
+	rms := make([]ipv4.Message, len(bufs))
+	for i := range bufs {
+		rms[i] = ipv4.Message{
+			Buffers: [][]byte{bufs[i]},
+			OOB:     make([]byte, unix.CmsgSpace(2)),
+		}
+	}
+
+	n, err := o.pc.ReadBatch(rms, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]*Packet, 0, n)
+	for i := 0; i < n; i++ {
+		full := bufs[i][:rms[i].N]
+		segSize := parseGROSegmentSize(rms[i].OOB[:rms[i].NN])
+
+		if segSize <= 0 || segSize >= len(full) {
+			atomic.AddUint64(&o.unbatched, 1)
+			buf := make([]byte, len(full))
+			copy(buf, full)
+			out = append(out, &Packet{Buf: buf, From: rms[i].Addr, Timestamp: now})
+			continue
+		}
+
+		atomic.AddUint64(&o.coalesced, uint64((len(full)+segSize-1)/segSize))
+		for off := 0; off < len(full); off += segSize {
+			end := off + segSize
+			if end > len(full) {
+				end = len(full)
+			}
+			seg := make([]byte, end-off)
+			copy(seg, full[off:end])
+			out = append(out, &Packet{Buf: seg, From: rms[i].Addr, Timestamp: now})
+		}
+	}
+	return out, nil
+}
+
+// gsoControlMessage builds a UDP_SEGMENT control message instructing the
+// kernel to split the outgoing buffer into datagrams of segmentSize
+// bytes apiece.
+func gsoControlMessage(segmentSize int) []byte {
+	oob := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	binary.LittleEndian.PutUint16(oob[unix.CmsgSpace(0):], uint16(segmentSize))
+	return oob
+}
+
+// parseGROSegmentSize looks for a UDP_GRO control message in oob and
+// returns the per-segment size the kernel coalesced, or 0 if none is
+// present (an ordinary, non-coalesced packet).
+func parseGROSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_UDP && msg.Header.Type == unix.UDP_GRO && len(msg.Data) >= 2 {
+			return int(binary.LittleEndian.Uint16(msg.Data))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+package memberlist
+
+import (
+	"errors"
+	"net"
+)
+
+// errUDPOffloadUnsupported is returned by the batched send/receive paths
+// on platforms that don't have UDP_SEGMENT/UDP_GRO. Callers should never
+// actually hit this since enabled() always reports false here, which
+// routes everything through the ordinary per-packet path instead.
+var errUDPOffloadUnsupported = errors.New("memberlist: UDP offload is only supported on linux")
+
+// udpOffload is a no-op stand-in on platforms other than Linux. NetTransport
+// always falls back to the per-packet WriteTo/ReadFrom path here.
+type udpOffload struct{}
+
+func newUDPOffload(conn *net.UDPConn, enable bool) *udpOffload {
+	return &udpOffload{}
+}
+
+func (o *udpOffload) enabled() bool          { return false }
+func (o *udpOffload) disable()               {}
+func (o *udpOffload) isFatal(err error) bool { return true }
+func (o *udpOffload) coalescedCount() uint64 { return 0 }
+func (o *udpOffload) unbatchedCount() uint64 { return 0 }
+func (o *udpOffload) addUnbatched(n int)     {}
+
+func (o *udpOffload) sendBatch(msgs [][]byte, addrs []*net.UDPAddr, segSize int) (int, error) {
+	return 0, errUDPOffloadUnsupported
+}
+
+func (o *udpOffload) recvBatch(bufs [][]byte) ([]*Packet, error) {
+	return nil, errUDPOffloadUnsupported
+}